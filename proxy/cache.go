@@ -0,0 +1,304 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cachedResponse is the typed value stored in the cache, replacing the raw
+// []byte that used to be written there. It carries everything needed to
+// replay the response later and to revalidate it once it goes stale.
+type cachedResponse struct {
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	VaryHeaders  []string          // header names the origin varied on
+	VaryValues   map[string]string // the request's values for those headers at store time
+	StoredAt     time.Time
+	LastModified time.Time
+}
+
+// varyIndex is stored under a request's base key so that a later request
+// knows which headers it must hash to find the right variant.
+type varyIndex struct {
+	Headers []string
+}
+
+// age returns how long ago the entry was stored (RFC 7234 §4.2.3, simplified:
+// we don't track apparent_age / response_delay separately since we don't
+// proxy through further caches).
+func (c *cachedResponse) age() time.Duration {
+	return time.Since(c.StoredAt)
+}
+
+// isCacheable checks if the request can be cached.
+func (p *ProxyHandler) isCacheable(r *http.Request) bool {
+	// Check HTTP method
+	if !p.cacheables[r.Method] {
+		return false
+	}
+
+	// Don't cache if there's an Authorization header
+	if r.Header.Get("Authorization") != "" {
+		return false
+	}
+
+	cc := parseCacheControl(r.Header.Get("Cache-Control"))
+	_, noStore := cc["no-store"]
+	return !noStore
+}
+
+// isResponseCacheable checks if the response can be cached.
+func (p *ProxyHandler) isResponseCacheable(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+		// these statuses are cacheable by default per RFC 7234 §3
+	default:
+		return false
+	}
+
+	cc := parseCacheControl(resp.Header.Get("Cache-Control"))
+	if _, noStore := cc["no-store"]; noStore {
+		return false
+	}
+	if _, private := cc["private"]; private {
+		return false
+	}
+
+	// Don't cache if there's a Set-Cookie header
+	if resp.Header.Get("Set-Cookie") != "" {
+		return false
+	}
+
+	return true
+}
+
+// createCacheKey creates the base key for a request; it does not account
+// for Vary, which is layered on top via variantCacheKey.
+func (p *ProxyHandler) createCacheKey(r *http.Request) string {
+	return r.Method + ":" + r.URL.String()
+}
+
+// varyIndexKey is where we stash the list of header names the origin varied
+// on for a given base key, so the next request knows what to hash.
+func varyIndexKey(baseKey string) string {
+	return "vary:" + baseKey
+}
+
+// variantCacheKey extends the base key with a hash of the request header
+// values named by varyHeaders, producing a distinct entry per Vary variant.
+func variantCacheKey(baseKey string, varyHeaders []string, header http.Header) string {
+	if len(varyHeaders) == 0 {
+		return baseKey
+	}
+	names := make([]string, len(varyHeaders))
+	copy(names, varyHeaders)
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(strings.ToLower(name)))
+		h.Write([]byte("="))
+		h.Write([]byte(header.Get(name)))
+		h.Write([]byte(";"))
+	}
+	return baseKey + "#" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// parseCacheControl splits a Cache-Control header into its directives,
+// lower-cased, mapping to their value (empty string if the directive has
+// none, e.g. "no-cache" vs "max-age=60").
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if idx := strings.Index(part, "="); idx != -1 {
+			name := strings.ToLower(strings.TrimSpace(part[:idx]))
+			val := strings.Trim(strings.TrimSpace(part[idx+1:]), `"`)
+			directives[name] = val
+		} else {
+			directives[strings.ToLower(part)] = ""
+		}
+	}
+	return directives
+}
+
+// freshnessLifetime computes how long a response may be served without
+// revalidation, per RFC 7234 §4.2.1: explicit max-age/s-maxage first, then
+// Expires, then a heuristic of 10% of the time since Last-Modified.
+func freshnessLifetime(header http.Header, storedAt time.Time) time.Duration {
+	cc := parseCacheControl(header.Get("Cache-Control"))
+
+	if raw, ok := cc["s-maxage"]; ok {
+		if seconds, err := strconv.Atoi(raw); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	if raw, ok := cc["max-age"]; ok {
+		if seconds, err := strconv.Atoi(raw); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	if expiresRaw := header.Get("Expires"); expiresRaw != "" {
+		if expires, err := http.ParseTime(expiresRaw); err == nil {
+			return expires.Sub(storedAt)
+		}
+	}
+
+	if lastModRaw := header.Get("Last-Modified"); lastModRaw != "" {
+		if lastMod, err := http.ParseTime(lastModRaw); err == nil && storedAt.After(lastMod) {
+			return storedAt.Sub(lastMod) / 10
+		}
+	}
+
+	return 0
+}
+
+// isFresh reports whether entry can still be served without revalidation,
+// honoring the requesting client's own Cache-Control overrides.
+func isFresh(entry *cachedResponse, reqCC map[string]string) bool {
+	if _, noCache := reqCC["no-cache"]; noCache {
+		return false
+	}
+
+	lifetime := freshnessLifetime(entry.Header, entry.StoredAt)
+	currentAge := entry.age()
+
+	if raw, ok := reqCC["max-age"]; ok {
+		if seconds, err := strconv.Atoi(raw); err == nil {
+			lifetime = time.Duration(seconds) * time.Second
+		}
+	}
+	if raw, ok := reqCC["min-fresh"]; ok {
+		if seconds, err := strconv.Atoi(raw); err == nil {
+			currentAge += time.Duration(seconds) * time.Second
+		}
+	}
+	if raw, ok := reqCC["max-stale"]; ok {
+		staleness := currentAge - lifetime
+		if raw == "" {
+			return true // any staleness is acceptable
+		}
+		if seconds, err := strconv.Atoi(raw); err == nil && staleness <= time.Duration(seconds)*time.Second {
+			return true
+		}
+	}
+
+	return currentAge < lifetime
+}
+
+// buildRevalidationRequest clones r into a conditional GET/HEAD against the
+// origin, adding validators from the stale cache entry.
+func buildRevalidationRequest(r *http.Request, entry *cachedResponse) *http.Request {
+	revalReq := r.Clone(r.Context())
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		revalReq.Header.Set("If-None-Match", etag)
+	}
+	if lastMod := entry.Header.Get("Last-Modified"); lastMod != "" {
+		revalReq.Header.Set("If-Modified-Since", lastMod)
+	}
+	return revalReq
+}
+
+// writeCachedHeaders writes the stored response's headers plus an Age
+// header reflecting how long the entry has been sitting in cache.
+func (p *ProxyHandler) writeCachedHeaders(w http.ResponseWriter, entry *cachedResponse) {
+	for key, values := range entry.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.Header().Set("Age", strconv.Itoa(int(entry.age().Seconds())))
+	w.Header().Set("X-Cache", "HIT")
+	w.WriteHeader(entry.StatusCode)
+}
+
+// writeCachedBody writes the stored response body to the client.
+func (p *ProxyHandler) writeCachedBody(w http.ResponseWriter, entry *cachedResponse) {
+	w.Write(entry.Body)
+}
+
+// cacheResponse stores resp/body under the Vary-aware key derived from
+// baseKey, recording the Vary header names so future lookups can compute
+// the right variant key.
+func (p *ProxyHandler) cacheResponse(r *http.Request, baseKey string, resp *http.Response, body []byte) {
+	varyHeaders := splitVaryHeaders(resp.Header.Get("Vary"))
+
+	entry := &cachedResponse{
+		StatusCode:  resp.StatusCode,
+		Header:      resp.Header.Clone(),
+		Body:        body,
+		VaryHeaders: varyHeaders,
+		StoredAt:    time.Now(),
+	}
+	if lastMod := resp.Header.Get("Last-Modified"); lastMod != "" {
+		if t, err := http.ParseTime(lastMod); err == nil {
+			entry.LastModified = t
+		}
+	}
+
+	key := baseKey
+	if len(varyHeaders) > 0 {
+		entry.VaryValues = make(map[string]string, len(varyHeaders))
+		for _, name := range varyHeaders {
+			entry.VaryValues[name] = r.Header.Get(name)
+		}
+		p.cache.Set(varyIndexKey(baseKey), &varyIndex{Headers: varyHeaders}, 0)
+		key = variantCacheKey(baseKey, varyHeaders, r.Header)
+	}
+	p.cache.Set(key, entry, freshnessLifetime(resp.Header, entry.StoredAt))
+}
+
+// lookupCache resolves the Vary-aware cache key for r and returns the
+// stored entry, if any.
+func (p *ProxyHandler) lookupCache(r *http.Request, baseKey string) (*cachedResponse, bool) {
+	key := baseKey
+	if item, found := p.cache.Get(varyIndexKey(baseKey)); found {
+		if idx, ok := item.Value.(*varyIndex); ok {
+			key = variantCacheKey(baseKey, idx.Headers, r.Header)
+		}
+	}
+
+	item, found := p.cache.Get(key)
+	if !found {
+		return nil, false
+	}
+	entry, ok := item.Value.(*cachedResponse)
+	if !ok {
+		return nil, false
+	}
+	return entry, true
+}
+
+// refreshCachedEntry updates a stale entry's headers and storage time after
+// a 304 Not Modified revalidation response, per RFC 7234 §4.3.3.
+func refreshCachedEntry(entry *cachedResponse, revalResp *http.Response) *cachedResponse {
+	for key, values := range revalResp.Header {
+		entry.Header[key] = values
+	}
+	entry.StoredAt = time.Now()
+	return entry
+}
+
+func splitVaryHeaders(vary string) []string {
+	if vary == "" {
+		return nil
+	}
+	var headers []string
+	for _, name := range strings.Split(vary, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			headers = append(headers, name)
+		}
+	}
+	return headers
+}
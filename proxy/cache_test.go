@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	cc := parseCacheControl(`max-age=60, no-cache, private="foo"`)
+
+	if got, want := cc["max-age"], "60"; got != want {
+		t.Errorf("max-age = %q, want %q", got, want)
+	}
+	if _, ok := cc["no-cache"]; !ok {
+		t.Error("expected no-cache directive to be present")
+	}
+	if got, want := cc["private"], "foo"; got != want {
+		t.Errorf("private = %q, want %q", got, want)
+	}
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{
+			name:   "s-maxage wins over max-age",
+			header: http.Header{"Cache-Control": {"max-age=10, s-maxage=20"}},
+			want:   20 * time.Second,
+		},
+		{
+			name:   "max-age without s-maxage",
+			header: http.Header{"Cache-Control": {"max-age=30"}},
+			want:   30 * time.Second,
+		},
+		{
+			name:   "expires header",
+			header: http.Header{"Expires": {now.Add(2 * time.Minute).Format(http.TimeFormat)}},
+			want:   2 * time.Minute,
+		},
+		{
+			name:   "no freshness information",
+			header: http.Header{},
+			want:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := freshnessLifetime(tt.header, now)
+			// Expires is only accurate to the second due to HTTP-date
+			// formatting, so allow a small tolerance there.
+			diff := got - tt.want
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff > time.Second {
+				t.Errorf("freshnessLifetime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFreshnessLifetimeHeuristic(t *testing.T) {
+	now := time.Now()
+	lastModified := now.Add(-100 * time.Second)
+	header := http.Header{"Last-Modified": {lastModified.Format(http.TimeFormat)}}
+
+	got := freshnessLifetime(header, now)
+	want := 10 * time.Second // 10% of the 100s since Last-Modified
+
+	diff := got - want
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > time.Second {
+		t.Errorf("freshnessLifetime() heuristic = %v, want ~%v", got, want)
+	}
+}
+
+func TestIsFresh(t *testing.T) {
+	entry := &cachedResponse{
+		Header:   http.Header{"Cache-Control": {"max-age=100"}},
+		StoredAt: time.Now().Add(-10 * time.Second),
+	}
+
+	if !isFresh(entry, map[string]string{}) {
+		t.Error("entry stored 10s ago with a 100s lifetime should be fresh")
+	}
+
+	if isFresh(entry, map[string]string{"no-cache": ""}) {
+		t.Error("request Cache-Control: no-cache should force revalidation")
+	}
+
+	if isFresh(entry, map[string]string{"max-age": "5"}) {
+		t.Error("request max-age=5 should make a 10s-old entry stale")
+	}
+
+	stale := &cachedResponse{
+		Header:   http.Header{"Cache-Control": {"max-age=1"}},
+		StoredAt: time.Now().Add(-10 * time.Second),
+	}
+	if !isFresh(stale, map[string]string{"max-stale": "30"}) {
+		t.Error("request max-stale=30 should accept a response only 9s past its lifetime")
+	}
+}
+
+func TestVariantCacheKey(t *testing.T) {
+	base := "GET:http://example.com/"
+
+	headerA := http.Header{"Accept-Encoding": {"gzip"}}
+	headerB := http.Header{"Accept-Encoding": {"br"}}
+
+	keyA := variantCacheKey(base, []string{"Accept-Encoding"}, headerA)
+	keyB := variantCacheKey(base, []string{"Accept-Encoding"}, headerB)
+
+	if keyA == keyB {
+		t.Error("different Vary header values should produce different cache keys")
+	}
+	if variantCacheKey(base, nil, headerA) != base {
+		t.Error("no Vary headers should fall back to the base key")
+	}
+}
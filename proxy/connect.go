@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dialTimeout bounds how long we wait to connect to a CONNECT target.
+const dialTimeout = 10 * time.Second
+
+// handleConnect services an HTTPS CONNECT request, turning this proxy into
+// a real forward proxy for TLS traffic. By default the two sides are
+// blindly tunneled; if TLS interception is enabled for the target host, we
+// terminate TLS ourselves instead so caching, domain filtering, and
+// logging can see the decrypted traffic.
+func (p *ProxyHandler) handleConnect(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Host
+	if host == "" {
+		host = r.Host
+	}
+	if host == "" {
+		http.Error(w, "CONNECT requires a target host", http.StatusBadRequest)
+		return
+	}
+
+	if !p.isDomainAllowed(stripPort(host)) {
+		http.Error(w, "Domain not allowed", http.StatusForbidden)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Connection hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, "Failed to hijack connection", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		log.Printf("CONNECT %s: failed to write 200 response: %v", host, err)
+		clientConn.Close()
+		return
+	}
+
+	if p.mitm != nil && p.mitm.shouldIntercept(stripPort(host)) {
+		log.Printf("CONNECT %s: intercepting with MITM", host)
+		p.mitm.serve(clientConn, host, p)
+		return
+	}
+
+	targetConn, err := net.DialTimeout("tcp", host, dialTimeout)
+	if err != nil {
+		log.Printf("CONNECT %s: failed to dial target: %v", host, err)
+		clientConn.Close()
+		return
+	}
+	tunnel(clientConn, targetConn)
+}
+
+// tunnel blindly copies bytes in both directions until either side closes,
+// then closes both.
+func tunnel(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		dst.Close()
+		done <- struct{}{}
+	}
+	go cp(a, b)
+	go cp(b, a)
+	<-done
+	<-done
+}
+
+// stripPort removes a trailing ":port" from a host[:port] string.
+func stripPort(hostport string) string {
+	if idx := strings.LastIndex(hostport, ":"); idx != -1 {
+		return hostport[:idx]
+	}
+	return hostport
+}
@@ -0,0 +1,85 @@
+package fastcgi
+
+import (
+	"encoding/binary"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// encodeParams serializes env into the name-value pair format PARAMS
+// records carry, per §3.4: each length is a single byte if < 128, or a
+// 4-byte big-endian value with the high bit set otherwise.
+func encodeParams(env map[string]string) []byte {
+	var buf []byte
+	for name, value := range env {
+		buf = append(buf, encodeLength(len(name))...)
+		buf = append(buf, encodeLength(len(value))...)
+		buf = append(buf, name...)
+		buf = append(buf, value...)
+	}
+	return buf
+}
+
+func encodeLength(n int) []byte {
+	if n < 128 {
+		return []byte{byte(n)}
+	}
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(n)|0x80000000)
+	return buf
+}
+
+// buildEnv derives the CGI/1.1 environment for req, as FastCGI expects it
+// in PARAMS records (§6.2). scriptFilename is the absolute path to the
+// script on the backend's filesystem, since FastCGI has no notion of the
+// proxy's own routing.
+func buildEnv(req *http.Request, scriptFilename string) map[string]string {
+	env := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_PROTOCOL":   req.Proto,
+		"SERVER_SOFTWARE":   "Go-Proxy-Server/1.0",
+		"REQUEST_METHOD":    req.Method,
+		"SCRIPT_FILENAME":   scriptFilename,
+		"SCRIPT_NAME":       req.URL.Path,
+		"REQUEST_URI":       req.URL.RequestURI(),
+		"QUERY_STRING":      req.URL.RawQuery,
+		"REMOTE_ADDR":       remoteAddr(req),
+		"SERVER_NAME":       req.URL.Hostname(),
+	}
+
+	if req.ContentLength >= 0 {
+		env["CONTENT_LENGTH"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		env["CONTENT_TYPE"] = ct
+	}
+	if port := req.URL.Port(); port != "" {
+		env["SERVER_PORT"] = port
+	}
+
+	for name, values := range req.Header {
+		if name == "Content-Type" || name == "Content-Length" {
+			continue
+		}
+		key := "HTTP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		env[key] = strings.Join(values, ", ")
+	}
+
+	return env
+}
+
+// remoteAddr prefers the leftmost X-Forwarded-For entry, since by the time
+// a request reaches the FastCGI transport it has usually already passed
+// through this proxy's own forwarding headers.
+func remoteAddr(req *http.Request) string {
+	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
+		parts := strings.Split(xff, ",")
+		return strings.TrimSpace(parts[0])
+	}
+	host := req.RemoteAddr
+	if idx := strings.LastIndex(host, ":"); idx != -1 {
+		return host[:idx]
+	}
+	return host
+}
@@ -0,0 +1,145 @@
+// Package fastcgi implements the FastCGI binary protocol (see the FastCGI
+// Specification, October 1996) well enough to act as a Transport for
+// PHP-FPM and similar backends.
+package fastcgi
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Record types, per the FastCGI spec §3.3.
+const (
+	typeBeginRequest = 1
+	typeAbortRequest = 2
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+	typeData         = 8
+)
+
+// Roles, per §4.1. Only the responder role is implemented.
+const (
+	roleResponder = 1
+)
+
+const (
+	version1 = 1
+
+	// maxContentLength is the largest content a single record may carry;
+	// longer payloads must be split across multiple records.
+	maxContentLength = 65535
+
+	// headerLen is the fixed size of a FastCGI record header.
+	headerLen = 8
+)
+
+// header is the 8-byte record header prefixing every FastCGI record.
+type header struct {
+	Version       uint8
+	Type          uint8
+	RequestID     uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+func (h header) marshal() []byte {
+	buf := make([]byte, headerLen)
+	buf[0] = h.Version
+	buf[1] = h.Type
+	binary.BigEndian.PutUint16(buf[2:4], h.RequestID)
+	binary.BigEndian.PutUint16(buf[4:6], h.ContentLength)
+	buf[6] = h.PaddingLength
+	buf[7] = h.Reserved
+	return buf
+}
+
+func readHeader(r io.Reader) (header, error) {
+	buf := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return header{}, err
+	}
+	return header{
+		Version:       buf[0],
+		Type:          buf[1],
+		RequestID:     binary.BigEndian.Uint16(buf[2:4]),
+		ContentLength: binary.BigEndian.Uint16(buf[4:6]),
+		PaddingLength: buf[6],
+		Reserved:      buf[7],
+	}, nil
+}
+
+// writeRecord writes content as one or more records of the given type,
+// splitting it into maxContentLength chunks and padding each to a multiple
+// of 8 bytes as recommended (not required) by the spec.
+func writeRecord(w io.Writer, recType uint8, requestID uint16, content []byte) error {
+	if len(content) == 0 {
+		return writeRecordChunk(w, recType, requestID, nil)
+	}
+	for len(content) > 0 {
+		n := len(content)
+		if n > maxContentLength {
+			n = maxContentLength
+		}
+		if err := writeRecordChunk(w, recType, requestID, content[:n]); err != nil {
+			return err
+		}
+		content = content[n:]
+	}
+	return nil
+}
+
+func writeRecordChunk(w io.Writer, recType uint8, requestID uint16, content []byte) error {
+	padding := (8 - len(content)%8) % 8
+	h := header{
+		Version:       version1,
+		Type:          recType,
+		RequestID:     requestID,
+		ContentLength: uint16(len(content)),
+		PaddingLength: uint8(padding),
+	}
+	if _, err := w.Write(h.marshal()); err != nil {
+		return err
+	}
+	if len(content) > 0 {
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+	}
+	if padding > 0 {
+		if _, err := w.Write(make([]byte, padding)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// beginRequestBody is the content of a BEGIN_REQUEST record, per §5.1.
+func beginRequestBody(role uint16, keepConn bool) []byte {
+	body := make([]byte, 8)
+	binary.BigEndian.PutUint16(body[0:2], role)
+	if keepConn {
+		body[2] = 1
+	}
+	return body
+}
+
+// endRequestBody is the content of an END_REQUEST record, per §5.6.
+type endRequestBody struct {
+	AppStatus      uint32
+	ProtocolStatus uint8
+}
+
+func parseEndRequestBody(content []byte) (endRequestBody, error) {
+	if len(content) < 8 {
+		return endRequestBody{}, errors.New("fastcgi: short END_REQUEST body")
+	}
+	return endRequestBody{
+		AppStatus:      binary.BigEndian.Uint32(content[0:4]),
+		ProtocolStatus: content[4],
+	}, nil
+}
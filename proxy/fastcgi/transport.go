@@ -0,0 +1,201 @@
+package fastcgi
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Transport speaks the FastCGI protocol to a single PHP-FPM-style backend,
+// satisfying the same RoundTrip shape as proxy.Transport so it can be
+// plugged into ProxyHandler for routes that front FastCGI applications.
+type Transport struct {
+	// Network is "tcp" or "unix".
+	Network string
+	// Address is a host:port for "tcp" or a socket path for "unix".
+	Address string
+	// ScriptFilename is the absolute path to the script to execute,
+	// typically a single front controller (e.g. PHP-FPM's index.php).
+	ScriptFilename string
+	// DialTimeout bounds connecting to the backend. 0 means no timeout.
+	DialTimeout time.Duration
+}
+
+// RoundTrip sends req to the FastCGI backend and returns its response.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	conn, err := net.DialTimeout(t.Network, t.Address, t.DialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("fastcgi: dial %s %s: %w", t.Network, t.Address, err)
+	}
+
+	const requestID = 1
+
+	// Stream STDIN from a goroutine while readResponse drains
+	// STDOUT/STDERR on the main path. A backend under load (PHP-FPM with
+	// a slow client, or a large request body) can start writing its
+	// response before it has consumed all of STDIN; serializing
+	// write-then-read would deadlock once both the proxy's and the
+	// backend's write buffers fill up with neither side draining the
+	// other.
+	sendErrCh := make(chan error, 1)
+	go func() {
+		sendErrCh <- t.sendRequest(conn, req, requestID)
+	}()
+
+	resp, err := readResponse(conn, requestID, req)
+	if err != nil {
+		// Close before waiting on sendErrCh: if sendRequest is still
+		// blocked writing STDIN, closing conn unblocks it (with an error)
+		// instead of waiting forever for a backend that's no longer
+		// reading. Prefer its error when it's more specific than whatever
+		// readResponse saw.
+		conn.Close()
+		if sendErr := <-sendErrCh; sendErr != nil {
+			err = sendErr
+		}
+		return nil, err
+	}
+	// A response was parsed successfully, so it's returned even if
+	// sendRequest later fails writing the remainder of STDIN: a backend
+	// that responds before consuming the whole request body (e.g.
+	// rejecting an oversized upload early) is expected to stop reading,
+	// and that doesn't invalidate the response it already sent. The
+	// buffered channel means the goroutine won't leak even if nothing
+	// ever reads from it.
+	// The body reader owns the connection from here on; it closes conn
+	// once the caller closes the response body.
+	resp.Body = &connClosingReader{Reader: resp.Body, conn: conn}
+	return resp, nil
+}
+
+func (t *Transport) sendRequest(conn net.Conn, req *http.Request, requestID uint16) error {
+	if err := writeRecord(conn, typeBeginRequest, requestID, beginRequestBody(roleResponder, false)); err != nil {
+		return err
+	}
+
+	env := buildEnv(req, t.ScriptFilename)
+	if err := writeRecord(conn, typeParams, requestID, encodeParams(env)); err != nil {
+		return err
+	}
+	if err := writeRecord(conn, typeParams, requestID, nil); err != nil { // terminator
+		return err
+	}
+
+	if req.Body != nil {
+		buf := make([]byte, maxContentLength)
+		for {
+			n, readErr := io.ReadFull(req.Body, buf)
+			if n > 0 {
+				if err := writeRecord(conn, typeStdin, requestID, buf[:n]); err != nil {
+					return err
+				}
+			}
+			if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+				break
+			}
+			if readErr != nil {
+				return readErr
+			}
+		}
+	}
+	return writeRecord(conn, typeStdin, requestID, nil) // terminator
+}
+
+// readResponse reads STDOUT/STDERR/END_REQUEST records until the request
+// completes, then parses the accumulated STDOUT as a CGI response: headers
+// followed by a blank line, then the body.
+func readResponse(conn net.Conn, requestID uint16, req *http.Request) (*http.Response, error) {
+	var stdout, stderr bytes.Buffer
+
+	for {
+		h, err := readHeader(conn)
+		if err != nil {
+			return nil, fmt.Errorf("fastcgi: reading record header: %w", err)
+		}
+
+		content := make([]byte, h.ContentLength)
+		if h.ContentLength > 0 {
+			if _, err := io.ReadFull(conn, content); err != nil {
+				return nil, fmt.Errorf("fastcgi: reading record body: %w", err)
+			}
+		}
+		if h.PaddingLength > 0 {
+			if _, err := io.CopyN(io.Discard, conn, int64(h.PaddingLength)); err != nil {
+				return nil, err
+			}
+		}
+
+		switch h.Type {
+		case typeStdout:
+			stdout.Write(content)
+		case typeStderr:
+			stderr.Write(content)
+		case typeEndRequest:
+			end, err := parseEndRequestBody(content)
+			if err != nil {
+				return nil, err
+			}
+			if end.ProtocolStatus != 0 {
+				return nil, fmt.Errorf("fastcgi: request rejected, protocol status %d", end.ProtocolStatus)
+			}
+			return parseCGIResponse(&stdout, req)
+		}
+	}
+}
+
+// parseCGIResponse turns a raw CGI-style output (headers, blank line,
+// body) into an *http.Response.
+func parseCGIResponse(raw *bytes.Buffer, req *http.Request) (*http.Response, error) {
+	reader := bufio.NewReader(raw)
+	header := make(http.Header)
+	statusCode := http.StatusOK
+
+	for {
+		line, err := reader.ReadString('\n')
+		trimmed := bytes.TrimRight([]byte(line), "\r\n")
+		if len(trimmed) == 0 {
+			break
+		}
+		if idx := bytes.IndexByte(trimmed, ':'); idx != -1 {
+			name := string(bytes.TrimSpace(trimmed[:idx]))
+			value := string(bytes.TrimSpace(trimmed[idx+1:]))
+			if name == "Status" {
+				fmt.Sscanf(value, "%d", &statusCode)
+				continue
+			}
+			header.Add(name, value)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	body, _ := io.ReadAll(reader)
+
+	resp := &http.Response{
+		StatusCode: statusCode,
+		Status:     http.StatusText(statusCode),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}
+	return resp, nil
+}
+
+// connClosingReader closes the underlying FastCGI connection when the
+// response body is closed; reading the body to EOF does not close it.
+type connClosingReader struct {
+	io.Reader
+	conn net.Conn
+}
+
+func (c *connClosingReader) Close() error {
+	return c.conn.Close()
+}
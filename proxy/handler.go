@@ -1,14 +1,15 @@
 package proxy
 
 import (
+	"crypto/subtle"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"strings"
 	"time"
 	"github.com/Jovial-Kanwadia/proxy-server/cache"
 	"github.com/Jovial-Kanwadia/proxy-server/config"
+	"github.com/Jovial-Kanwadia/proxy-server/upstream"
 )
 
 // ProxyHandler handles HTTP requests by forwarding them to the target server
@@ -17,6 +18,32 @@ type ProxyHandler struct {
 	client     *http.Client
 	config     *config.Config
 	cacheables map[string]bool // Map of cacheable HTTP methods
+	bufferPool *BufferPool
+	pools      map[string]*upstream.Pool // upstream pools keyed by route's pool name
+	transports map[string]Transport      // named transports keyed by route's transport name, e.g. "http", "fastcgi"
+	mitm       *mitmInterceptor          // non-nil once EnableTLSIntercept has been called
+
+	// FlushInterval controls how often streamed response bodies are
+	// flushed to the client. 0 uses defaultFlushInterval, -1 flushes
+	// after every write (useful for low-latency SSE).
+	FlushInterval time.Duration
+
+	// MaxCacheableBodySize caps how much of a cacheable response body is
+	// buffered for storage while it's being streamed to the client. If the
+	// body exceeds this, it is still streamed but not cached. 0 uses
+	// defaultStreamThreshold.
+	MaxCacheableBodySize int64
+
+	// ExtraStripHeaders names additional headers, beyond the standard
+	// hop-by-hop set, that should never be forwarded to the origin.
+	ExtraStripHeaders []string
+
+	// AdminToken gates the /-/upstreams status endpoint: requests must send
+	// it via the X-Admin-Token header. It's unset (and the endpoint refuses
+	// all requests) by default, since upstream hosts, weights and failure
+	// counts are internal topology that shouldn't be exposed to proxy
+	// clients without an explicit opt-in.
+	AdminToken string
 }
 
 // NewProxyHandler creates a new ProxyHandler
@@ -44,14 +71,50 @@ func NewProxyHandler(cache cache.Cache, cfg *config.Config) *ProxyHandler {
 		client:     client,
 		config:     cfg,
 		cacheables: cacheables,
+		bufferPool: NewBufferPool(defaultBufferSize),
+		transports: map[string]Transport{
+			"http": &httpTransport{client: client},
+		},
+		FlushInterval: defaultFlushInterval,
 	}
 }
 
+// SetUpstreamPools wires the named upstream pools into the handler so that
+// requests matching a route in config.Config.Routes are load-balanced
+// across a backend pool instead of forwarded to the client-supplied host.
+func (p *ProxyHandler) SetUpstreamPools(pools map[string]*upstream.Pool) {
+	p.pools = pools
+}
+
 // ServeHTTP implements the http.Handler interface
 func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Log the request
 	log.Printf("Proxying request: %s %s", r.Method, r.URL.String())
 
+	// Serve the upstream pool status endpoint directly; it's a relative
+	// path so it would otherwise fail the proxy-request shape check below.
+	// It exposes internal backend topology, so it's gated behind AdminToken
+	// and fails closed if one hasn't been configured. r.URL.Host == "" also
+	// excludes this from forward-proxied absolute-URI requests, so a client
+	// can't reach the admin handler by proxying a request for some
+	// third-party "http://anysite.example/-/upstreams".
+	if r.URL.Path == AdminUpstreamsPath && r.URL.Host == "" {
+		if !p.isAdminAuthorized(r) {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		upstream.AdminHandler(p.pools).ServeHTTP(w, r)
+		return
+	}
+
+	// Browsers send CONNECT host:443 to tunnel HTTPS through a forward
+	// proxy; it carries no scheme/URL host the way proxied requests do, so
+	// it must be handled before the shape check below.
+	if r.Method == http.MethodConnect {
+		p.handleConnect(w, r)
+		return
+	}
+
 	// Check if the request URL is properly formed
 	if r.URL.Scheme == "" || r.URL.Host == "" {
 		// This is likely a direct request to the proxy without the target URL
@@ -65,45 +128,91 @@ func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// WebSocket (or other protocol-switch) requests can't be served from
+	// cache or read into memory like a normal response; hand them off to
+	// the hijack-and-splice path instead.
+	if isUpgradeRequest(r) {
+		p.handleUpgrade(w, r)
+		return
+	}
+
 	// Check if we can use the cache for this request
+	var cacheKey string
+	var reuseResp *http.Response
 	if p.isCacheable(r) {
-		cacheKey := p.createCacheKey(r)
-		
-		// Try to get from cache
-		if item, found := p.cache.Get(cacheKey); found {
-			log.Printf("Cache hit for %s", cacheKey)
-			
-			// Parse the cached response
-			response := item.Value
-			
-			// Write headers from cached response
-			p.writeCachedHeaders(w, response)
-			
-			// Write body from cached response
-			p.writeCachedBody(w, response)
-			
-			return
+		cacheKey = p.createCacheKey(r)
+
+		if entry, found := p.lookupCache(r, cacheKey); found {
+			reqCC := parseCacheControl(r.Header.Get("Cache-Control"))
+
+			if isFresh(entry, reqCC) {
+				log.Printf("Cache hit (fresh) for %s", cacheKey)
+				p.writeCachedHeaders(w, entry)
+				p.writeCachedBody(w, entry)
+				return
+			}
+
+			log.Printf("Cache stale for %s, revalidating", cacheKey)
+			handled, freshResp := p.revalidate(w, r, cacheKey, entry)
+			if handled {
+				return
+			}
+			// freshResp is non-nil when the origin sent a full response
+			// instead of 304; reuse it below instead of fetching again.
+			reuseResp = freshResp
+		} else {
+			log.Printf("Cache miss for %s", cacheKey)
 		}
-		
-		log.Printf("Cache miss for %s", cacheKey)
 	}
 
-	// Clone the request for the target server
-	proxyReq, err := p.cloneRequest(r)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating proxy request: %v", err), http.StatusInternalServerError)
-		return
-	}
+	var resp *http.Response
+	if reuseResp != nil {
+		resp = reuseResp
+	} else {
+		// If this route is bound to an upstream pool, pick a backend
+		// instead of forwarding to the client-supplied host.
+		pool := p.resolvePool(r)
+		var selected *upstream.Upstream
+		if pool != nil {
+			var poolErr error
+			selected, poolErr = pool.Select(r)
+			if poolErr != nil {
+				http.Error(w, "No healthy upstream available", http.StatusBadGateway)
+				return
+			}
+		}
 
-	// Forward the request to the target server
-	resp, err := p.client.Do(proxyReq)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error forwarding request: %v", err), http.StatusBadGateway)
-		return
+		// Clone the request for the target server
+		proxyReq, err := p.cloneRequest(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error creating proxy request: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if pool != nil {
+			if err := rewriteToUpstream(proxyReq, selected); err != nil {
+				http.Error(w, fmt.Sprintf("Error selecting upstream: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// Forward the request to the target server
+		var fetchErr error
+		resp, fetchErr = p.transportFor(r).RoundTrip(proxyReq)
+		if pool != nil {
+			pool.Release(selected, fetchErr == nil && resp != nil && resp.StatusCode < 500)
+		}
+		if fetchErr != nil {
+			http.Error(w, fmt.Sprintf("Error forwarding request: %v", fetchErr), http.StatusBadGateway)
+			return
+		}
 	}
 	defer resp.Body.Close()
 
-	// Copy headers from target response to client response
+	// Copy headers from target response to client response, dropping
+	// hop-by-hop headers that describe the proxy<->origin connection
+	// rather than the representation itself.
+	stripHopByHopHeaders(resp.Header, p.ExtraStripHeaders)
 	for key, values := range resp.Header {
 		for _, value := range values {
 			w.Header().Add(key, value)
@@ -116,34 +225,22 @@ func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Set status code
 	w.WriteHeader(resp.StatusCode)
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return
-	}
-
-	// Check if we should cache this response
-	if p.isCacheable(r) && p.isResponseCacheable(resp) {
-		cacheKey := p.createCacheKey(r)
-		
-		// Store response in cache
-		p.cacheResponse(cacheKey, resp, body)
-	}
-
-	// Write response body to client
-	if _, err := w.Write(body); err != nil {
-		log.Printf("Error writing response body: %v", err)
-	}
+	// Stream the response body to the client, caching it on the side if
+	// it qualifies and isn't too large.
+	p.streamResponse(w, r, resp, cacheKey)
 }
 
-// isDomainAllowed checks if the domain is allowed based on configuration
+// isDomainAllowed checks if the domain is allowed based on configuration.
+// host may carry a ":port" suffix (as CONNECT targets and Host headers
+// both do), so it's stripped before matching against AllowedDomains.
 func (p *ProxyHandler) isDomainAllowed(host string) bool {
 	// If no allowed domains are specified, all domains are allowed
 	if len(p.config.AllowedDomains) == 0 {
 		return true
 	}
 
+	host = stripPort(host)
+
 	// Check if the host is in the allowed domains list
 	for _, domain := range p.config.AllowedDomains {
 		if strings.HasSuffix(host, domain) {
@@ -154,50 +251,70 @@ func (p *ProxyHandler) isDomainAllowed(host string) bool {
 	return false
 }
 
-// isCacheable checks if the request can be cached
-func (p *ProxyHandler) isCacheable(r *http.Request) bool {
-	// Check HTTP method
-	if !p.cacheables[r.Method] {
-		return false
-	}
-
-	// Don't cache if there's an Authorization header
-	if r.Header.Get("Authorization") != "" {
+// isAdminAuthorized reports whether r carries the configured AdminToken via
+// the X-Admin-Token header. It fails closed: an unset AdminToken rejects
+// every request rather than leaving the admin endpoint open by default.
+func (p *ProxyHandler) isAdminAuthorized(r *http.Request) bool {
+	if p.AdminToken == "" {
 		return false
 	}
-
-	// Don't cache if there's a Cache-Control: no-store header
-	cacheControl := r.Header.Get("Cache-Control")
-	return !strings.Contains(cacheControl, "no-store")
-
-	// return true
+	supplied := r.Header.Get("X-Admin-Token")
+	return subtle.ConstantTimeCompare([]byte(supplied), []byte(p.AdminToken)) == 1
 }
 
-// isResponseCacheable checks if the response can be cached
-func (p *ProxyHandler) isResponseCacheable(resp *http.Response) bool {
-	// Only cache successful responses
-	if resp.StatusCode != http.StatusOK {
-		return false
+// revalidate re-checks a stale cache entry against the origin using
+// conditional headers, routing through the same upstream pool selection a
+// normal fetch would use when the route is pool-bound. On a 304 it
+// refreshes and serves the stored entry itself, returning handled=true. On
+// a full response, the origin decided to send a fresh representation
+// instead of confirming the cached one; in that case revalidate returns
+// handled=false and the already-fetched response so the caller can serve
+// and cache it directly instead of discarding it and issuing a second
+// identical request.
+func (p *ProxyHandler) revalidate(w http.ResponseWriter, r *http.Request, cacheKey string, entry *cachedResponse) (handled bool, fresh *http.Response) {
+	revalReq, err := p.cloneRequest(buildRevalidationRequest(r, entry))
+	if err != nil {
+		log.Printf("Error building revalidation request for %s: %v", cacheKey, err)
+		return false, nil
 	}
 
-	// Don't cache if there's a Cache-Control: no-store header
-	cacheControl := resp.Header.Get("Cache-Control")
-	if strings.Contains(cacheControl, "no-store") {
-		return false
+	// If this route is bound to an upstream pool, pick a backend instead
+	// of sending the conditional request to the client-supplied virtual
+	// host, the same way the main fetch path in ServeHTTP does.
+	pool := p.resolvePool(r)
+	var selected *upstream.Upstream
+	if pool != nil {
+		var poolErr error
+		selected, poolErr = pool.Select(r)
+		if poolErr != nil {
+			log.Printf("No healthy upstream to revalidate %s: %v", cacheKey, poolErr)
+			return false, nil
+		}
+		if err := rewriteToUpstream(revalReq, selected); err != nil {
+			log.Printf("Error selecting upstream to revalidate %s: %v", cacheKey, err)
+			return false, nil
+		}
 	}
 
-	// Don't cache if there's a Set-Cookie header
-	if resp.Header.Get("Set-Cookie") != "" {
-		return false
+	resp, err := p.transportFor(r).RoundTrip(revalReq)
+	if pool != nil {
+		pool.Release(selected, err == nil && resp != nil && resp.StatusCode < 500)
+	}
+	if err != nil {
+		log.Printf("Revalidation request failed for %s: %v", cacheKey, err)
+		return false, nil
 	}
 
-	return true
-}
+	if resp.StatusCode != http.StatusNotModified {
+		return false, resp
+	}
+	defer resp.Body.Close()
 
-// createCacheKey creates a unique key for the request
-func (p *ProxyHandler) createCacheKey(r *http.Request) string {
-	// Simple key format: METHOD:URL
-	return fmt.Sprintf("%s:%s", r.Method, r.URL.String())
+	refreshed := refreshCachedEntry(entry, resp)
+	p.cacheResponse(r, cacheKey, &http.Response{StatusCode: refreshed.StatusCode, Header: refreshed.Header}, refreshed.Body)
+	p.writeCachedHeaders(w, refreshed)
+	p.writeCachedBody(w, refreshed)
+	return true, nil
 }
 
 // cloneRequest creates a new request for the target server
@@ -223,27 +340,9 @@ func (p *ProxyHandler) cloneRequest(r *http.Request) (*http.Request, error) {
 	proxyReq.Header.Set("X-Forwarded-For", r.RemoteAddr)
 	proxyReq.Header.Set("X-Forwarded-Host", r.Host)
 
-	// Don't pass the Connection header
-	proxyReq.Header.Del("Connection")
+	// Strip hop-by-hop headers (RFC 7230 §6.1) plus any extra headers this
+	// handler has been configured not to forward.
+	stripHopByHopHeaders(proxyReq.Header, p.ExtraStripHeaders)
 
 	return proxyReq, nil
 }
-
-// We'll implement these methods in the next steps
-func (p *ProxyHandler) writeCachedHeaders(w http.ResponseWriter, response []byte) {
-	// This will be implemented in the next step
-	// For now, set a placeholder header
-	w.Header().Set("X-Cache", "HIT")
-}
-
-func (p *ProxyHandler) writeCachedBody(w http.ResponseWriter, response []byte) {
-	// This will be implemented in the next step
-	// For now, write the response directly
-	w.Write(response)
-}
-
-func (p *ProxyHandler) cacheResponse(key string, resp *http.Response, body []byte) {
-	// This will be implemented in the next step
-	// For now, just log that we would cache this
-	log.Printf("Would cache response for %s (%d bytes)", key, len(body))
-}
\ No newline at end of file
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jovial-Kanwadia/proxy-server/config"
+)
+
+// mitmInterceptor terminates TLS on behalf of the client using leaf
+// certificates minted on the fly and signed by a configured CA, so that
+// HTTPS traffic can be fed back through ServeHTTP like any other request.
+type mitmInterceptor struct {
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+
+	allow []string
+	deny  []string
+
+	mu    sync.Mutex
+	cache map[string]*tls.Certificate
+}
+
+// EnableTLSIntercept loads the CA certificate/key named in cfg and turns on
+// MITM interception for CONNECT requests to hosts that pass the allow/deny
+// lists.
+func (p *ProxyHandler) EnableTLSIntercept(cfg *config.TLSIntercept) error {
+	caTLSCert, err := tls.LoadX509KeyPair(cfg.CACertFile, cfg.CAKeyFile)
+	if err != nil {
+		return fmt.Errorf("mitm: loading CA cert/key: %w", err)
+	}
+	caCert, err := x509.ParseCertificate(caTLSCert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("mitm: parsing CA certificate: %w", err)
+	}
+	caKey, ok := caTLSCert.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return errors.New("mitm: CA private key must be ECDSA")
+	}
+
+	p.mitm = &mitmInterceptor{
+		caCert: caCert,
+		caKey:  caKey,
+		allow:  cfg.AllowHosts,
+		deny:   cfg.DenyHosts,
+		cache:  make(map[string]*tls.Certificate),
+	}
+	return nil
+}
+
+// shouldIntercept reports whether host is eligible for MITM, honoring an
+// explicit deny list first and then an allow list (if one is configured,
+// only listed hosts are intercepted).
+func (m *mitmInterceptor) shouldIntercept(host string) bool {
+	for _, d := range m.deny {
+		if matchesHost(host, d) {
+			return false
+		}
+	}
+	if len(m.allow) == 0 {
+		return true
+	}
+	for _, a := range m.allow {
+		if matchesHost(host, a) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesHost(host, pattern string) bool {
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// serve terminates TLS on clientConn using a leaf certificate for host,
+// then runs a single-connection HTTP server over the decrypted stream so
+// the proxy's normal request handling (caching, filtering, logging)
+// applies to requests the client makes.
+func (m *mitmInterceptor) serve(clientConn net.Conn, host string, handler http.Handler) {
+	tlsConn := tls.Server(clientConn, &tls.Config{
+		GetCertificate: m.certFor,
+	})
+
+	srv := &http.Server{
+		Handler: &mitmRewriter{inner: handler, host: host},
+	}
+	if err := srv.Serve(newSingleConnListener(tlsConn)); err != nil && !errors.Is(err, errSingleConnClosed) {
+		log.Printf("mitm %s: serving decrypted connection: %v", host, err)
+	}
+}
+
+// certFor returns a leaf certificate for the SNI name in hello, minting
+// and caching one signed by our CA if we haven't seen it before.
+func (m *mitmInterceptor) certFor(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := hello.ServerName
+	if name == "" {
+		return nil, errors.New("mitm: client did not send SNI")
+	}
+
+	m.mu.Lock()
+	if cert, ok := m.cache[name]; ok {
+		m.mu.Unlock()
+		return cert, nil
+	}
+	m.mu.Unlock()
+
+	cert, err := m.generateLeaf(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.cache[name] = cert
+	m.mu.Unlock()
+	return cert, nil
+}
+
+// generateLeaf mints a new leaf certificate for name, signed by our CA.
+func (m *mitmInterceptor) generateLeaf(name string) (*tls.Certificate, error) {
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("mitm: generating leaf key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("mitm: generating serial: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, m.caCert, &leafKey.PublicKey, m.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("mitm: signing leaf certificate: %w", err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, m.caCert.Raw},
+		PrivateKey:  leafKey,
+	}, nil
+}
+
+// mitmRewriter sets each decrypted request's URL back to the original
+// HTTPS target before handing it to the proxy's normal handler, since an
+// intercepted request line only carries the path, not the scheme/host the
+// client originally CONNECTed to.
+type mitmRewriter struct {
+	inner http.Handler
+	host  string
+}
+
+func (m *mitmRewriter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.URL.Scheme = "https"
+	r.URL.Host = m.host
+	m.inner.ServeHTTP(w, r)
+}
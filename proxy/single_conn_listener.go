@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// errSingleConnClosed is returned by singleConnListener.Accept once its one
+// connection has been handed out; it signals
+// http.Server.Serve to stop without logging a real error.
+var errSingleConnClosed = errors.New("proxy: single connection listener closed")
+
+// singleConnListener is a net.Listener that yields exactly one
+// already-established connection, letting us run an *http.Server over a
+// connection we hijacked and upgraded ourselves (e.g. after a CONNECT +
+// TLS handshake) instead of reimplementing HTTP/1.1 framing by hand.
+type singleConnListener struct {
+	conn     net.Conn
+	once     sync.Once
+	accepted bool
+	mu       sync.Mutex
+}
+
+func newSingleConnListener(conn net.Conn) *singleConnListener {
+	return &singleConnListener{conn: conn}
+}
+
+// Accept returns the wrapped connection exactly once; every call after
+// that returns errSingleConnClosed so http.Server.Serve exits instead of
+// looping forever waiting for a second connection that will never come.
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.accepted {
+		return nil, errSingleConnClosed
+	}
+	l.accepted = true
+	return l.conn, nil
+}
+
+// Close leaves the connection open: http.Server.Serve closes its listener
+// as soon as the second Accept fails, while the connection is still being
+// served, and the server closes the connection itself when it is done.
+func (l *singleConnListener) Close() error {
+	return nil
+}
+
+func (l *singleConnListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
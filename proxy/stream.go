@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultFlushInterval is how often a streamed response is flushed to
+	// the client when the handler doesn't override FlushInterval.
+	defaultFlushInterval = 100 * time.Millisecond
+
+	// defaultStreamThreshold is the response size above which we start
+	// flushing periodically instead of waiting for the copy to finish,
+	// even if the response isn't otherwise identified as streaming.
+	defaultStreamThreshold = 1 << 20 // 1 MiB
+
+	// defaultBufferSize is the chunk size used for the copy loop and for
+	// entries drawn from the BufferPool.
+	defaultBufferSize = 32 * 1024
+)
+
+// BufferPool is a sync.Pool of reusable byte slices, used to avoid
+// allocating a fresh copy buffer per request.
+type BufferPool struct {
+	pool sync.Pool
+}
+
+// NewBufferPool creates a BufferPool whose buffers are sized bufSize bytes.
+func NewBufferPool(bufSize int) *BufferPool {
+	if bufSize <= 0 {
+		bufSize = defaultBufferSize
+	}
+	return &BufferPool{
+		pool: sync.Pool{
+			New: func() interface{} {
+				return make([]byte, bufSize)
+			},
+		},
+	}
+}
+
+// Get returns a buffer from the pool.
+func (b *BufferPool) Get() []byte {
+	return b.pool.Get().([]byte)
+}
+
+// Put returns a buffer to the pool.
+func (b *BufferPool) Put(buf []byte) {
+	b.pool.Put(buf) //nolint:staticcheck // size is fixed, no risk of leaking oversized slices
+}
+
+// flushWriter wraps an http.ResponseWriter so that, while a response body
+// is being streamed through it, the underlying connection is flushed on a
+// fixed interval rather than only once the handler returns. An interval of
+// 0 disables the periodic goroutine; a negative interval flushes after
+// every single Write instead.
+type flushWriter struct {
+	w        http.ResponseWriter
+	flusher  http.Flusher
+	interval time.Duration
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	// mu guards w, since the ticker goroutine calls Flush concurrently
+	// with Write calls from the copy loop, and net/http's ResponseWriter
+	// isn't safe for concurrent use.
+	mu sync.Mutex
+}
+
+// newFlushWriter wraps w, starting a background flush loop if interval > 0.
+// If w doesn't implement http.Flusher, it is returned unwrapped.
+func newFlushWriter(w http.ResponseWriter, interval time.Duration) io.Writer {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return w
+	}
+
+	fw := &flushWriter{w: w, flusher: flusher, interval: interval, stopCh: make(chan struct{})}
+	if interval > 0 {
+		go fw.flushLoop()
+	}
+	return fw
+}
+
+func (fw *flushWriter) flushLoop() {
+	ticker := time.NewTicker(fw.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fw.mu.Lock()
+			fw.flusher.Flush()
+			fw.mu.Unlock()
+		case <-fw.stopCh:
+			return
+		}
+	}
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	n, err := fw.w.Write(p)
+	if fw.interval < 0 {
+		fw.flusher.Flush()
+	}
+	return n, err
+}
+
+func (fw *flushWriter) Close() {
+	fw.stopOnce.Do(func() { close(fw.stopCh) })
+}
+
+// shouldStreamImmediately reports whether resp should be flushed to the
+// client as it arrives rather than relying solely on the final flush.
+func shouldStreamImmediately(resp *http.Response) bool {
+	if strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return true
+	}
+	if resp.ContentLength < 0 {
+		// Chunked transfer or otherwise unknown length.
+		return true
+	}
+	if resp.ContentLength > defaultStreamThreshold {
+		return true
+	}
+	return false
+}
+
+// boundedBuffer accumulates up to limit bytes; once a write would exceed
+// limit it discards everything, including what it already held, and
+// records that it overflowed. It's used to tee a cacheable response body
+// into memory without buffering unbounded bodies.
+type boundedBuffer struct {
+	limit      int64
+	buf        []byte
+	overflowed bool
+}
+
+func newBoundedBuffer(limit int64) *boundedBuffer {
+	return &boundedBuffer{limit: limit}
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	if b.overflowed {
+		return len(p), nil
+	}
+	if int64(len(b.buf)+len(p)) > b.limit {
+		b.overflowed = true
+		b.buf = nil
+		return len(p), nil
+	}
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+// streamResponse copies resp.Body to w, flushing periodically when the
+// response looks like it benefits from low-latency delivery (SSE, chunked,
+// or large bodies). When cacheKey is non-empty and the response is
+// cacheable, the body is teed into a bounded buffer and stored in the
+// cache once the copy completes, unless it exceeded MaxCacheableBodySize.
+func (p *ProxyHandler) streamResponse(w http.ResponseWriter, r *http.Request, resp *http.Response, cacheKey string) {
+	var dest io.Writer = w
+	if shouldStreamImmediately(resp) {
+		interval := p.FlushInterval
+		if interval == 0 {
+			interval = defaultFlushInterval
+		}
+		fw := newFlushWriter(w, interval)
+		dest = fw
+		if closer, ok := fw.(*flushWriter); ok {
+			defer closer.Close()
+		}
+	}
+
+	var bound *boundedBuffer
+	shouldCache := cacheKey != "" && p.isResponseCacheable(resp)
+	if shouldCache {
+		limit := p.MaxCacheableBodySize
+		if limit <= 0 {
+			limit = defaultStreamThreshold
+		}
+		bound = newBoundedBuffer(limit)
+		dest = io.MultiWriter(dest, bound)
+	}
+
+	buf := p.bufferPool.Get()
+	defer p.bufferPool.Put(buf)
+
+	if _, err := io.CopyBuffer(dest, resp.Body, buf); err != nil {
+		log.Printf("Error streaming response body: %v", err)
+		return
+	}
+
+	if shouldCache && bound != nil && !bound.overflowed {
+		p.cacheResponse(r, cacheKey, resp, bound.buf)
+	}
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import "net/http"
+
+// Transport abstracts how a proxied request actually reaches a backend,
+// letting ProxyHandler forward to plain HTTP origins, FastCGI application
+// servers, or anything else that can turn a request into a response.
+type Transport interface {
+	RoundTrip(req *http.Request) (*http.Response, error)
+}
+
+// httpTransport adapts the handler's *http.Client (with its timeout and
+// redirect policy) to the Transport interface.
+type httpTransport struct {
+	client *http.Client
+}
+
+// RoundTrip forwards req using the wrapped client.
+func (t *httpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.client.Do(req)
+}
+
+// SetTransports registers additional named transports (e.g. "fastcgi")
+// alongside the default "http" one, so routes can select between them.
+func (p *ProxyHandler) SetTransports(transports map[string]Transport) {
+	if p.transports == nil {
+		p.transports = make(map[string]Transport, len(transports)+1)
+	}
+	for name, t := range transports {
+		p.transports[name] = t
+	}
+}
+
+// transportFor resolves which Transport should carry r, based on the
+// Transport name of the route r matches, falling back to plain HTTP.
+func (p *ProxyHandler) transportFor(r *http.Request) Transport {
+	if route, ok := p.matchRoute(r); ok && route.Transport != "" {
+		if t, ok := p.transports[route.Transport]; ok {
+			return t
+		}
+	}
+	return p.transports["http"]
+}
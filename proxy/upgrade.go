@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// hopByHopHeaders lists the connection-specific headers RFC 7230 §6.1 says
+// must not be forwarded by a proxy on an ordinary request/response. They
+// are stripped in cloneRequest but deliberately preserved on upgrade
+// requests, since Connection/Upgrade/Sec-WebSocket-* are exactly what
+// negotiates the protocol switch.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailers",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// stripHopByHopHeaders removes the standard hop-by-hop headers plus any
+// handler-configured extras (the "non-mutable header" list other proxy
+// frameworks expose) from header.
+func stripHopByHopHeaders(header http.Header, extra []string) {
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+	for _, name := range extra {
+		header.Del(name)
+	}
+}
+
+// isUpgradeRequest reports whether r is asking to switch protocols, e.g. a
+// WebSocket handshake (Connection: Upgrade, Upgrade: websocket).
+func isUpgradeRequest(r *http.Request) bool {
+	return headerTokenContains(r.Header, "Connection", "upgrade") && r.Header.Get("Upgrade") != ""
+}
+
+// headerTokenContains reports whether header's comma-separated value for
+// name includes token, case-insensitively.
+func headerTokenContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleUpgrade proxies a protocol-switch request (WebSocket, or any other
+// Upgrade token) by dialing the upstream directly, replaying the request
+// with its hop-by-hop headers intact, and splicing the raw connections
+// together once the upstream confirms the switch with a 101 response.
+func (p *ProxyHandler) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	targetConn, err := dialUpstream(r.URL)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error connecting to upstream: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	proxyReq, err := p.cloneUpgradeRequest(r)
+	if err != nil {
+		targetConn.Close()
+		http.Error(w, fmt.Sprintf("Error creating proxy request: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := proxyReq.Write(targetConn); err != nil {
+		targetConn.Close()
+		http.Error(w, fmt.Sprintf("Error forwarding upgrade request: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	targetReader := bufio.NewReader(targetConn)
+	resp, err := http.ReadResponse(targetReader, proxyReq)
+	if err != nil {
+		targetConn.Close()
+		http.Error(w, fmt.Sprintf("Error reading upstream response: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		resp.Body.Close()
+		targetConn.Close()
+		http.Error(w, "Connection hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		resp.Body.Close()
+		targetConn.Close()
+		return
+	}
+
+	if err := resp.Write(clientConn); err != nil {
+		log.Printf("Error writing upgrade response to client: %v", err)
+		clientConn.Close()
+		targetConn.Close()
+		return
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// Not actually switching protocols after all; nothing more to
+		// splice, the response above was the whole reply.
+		clientConn.Close()
+		targetConn.Close()
+		return
+	}
+
+	log.Printf("Upgrading connection to %s for %s", r.Header.Get("Upgrade"), r.URL.Host)
+
+	// Any bytes the client already sent past the handshake (or the server
+	// sent past its 101) are sitting in the hijacker's/ReadResponse's
+	// buffers; drain those before splicing the raw connections.
+	if n := clientBuf.Reader.Buffered(); n > 0 {
+		buffered := make([]byte, n)
+		clientBuf.Reader.Read(buffered)
+		targetConn.Write(buffered)
+	}
+	if n := targetReader.Buffered(); n > 0 {
+		buffered := make([]byte, n)
+		targetReader.Read(buffered)
+		clientConn.Write(buffered)
+	}
+
+	tunnel(clientConn, targetConn)
+}
+
+// cloneUpgradeRequest is like cloneRequest but preserves the hop-by-hop
+// headers that negotiate the protocol switch instead of stripping them.
+func (p *ProxyHandler) cloneUpgradeRequest(r *http.Request) (*http.Request, error) {
+	proxyReq, err := http.NewRequest(r.Method, r.URL.String(), r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyReq.Header = make(http.Header)
+	for key, values := range r.Header {
+		for _, value := range values {
+			proxyReq.Header.Add(key, value)
+		}
+	}
+
+	proxyReq.Header.Set("X-Forwarded-For", r.RemoteAddr)
+	proxyReq.Header.Set("X-Forwarded-Host", r.Host)
+	proxyReq.Proto = r.Proto
+	proxyReq.ProtoMajor = r.ProtoMajor
+	proxyReq.ProtoMinor = r.ProtoMinor
+
+	return proxyReq, nil
+}
+
+// dialUpstream opens a plain or TLS connection to u, depending on scheme,
+// defaulting the port the way http.Transport would.
+func dialUpstream(u *url.URL) (net.Conn, error) {
+	host := u.Host
+	if !strings.Contains(host, ":") {
+		if u.Scheme == "https" || u.Scheme == "wss" {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+
+	if u.Scheme == "https" || u.Scheme == "wss" {
+		return tls.Dial("tcp", host, &tls.Config{ServerName: u.Hostname()})
+	}
+	return net.DialTimeout("tcp", host, dialTimeout)
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Jovial-Kanwadia/proxy-server/config"
+	"github.com/Jovial-Kanwadia/proxy-server/upstream"
+)
+
+// matchRoute finds the configured route bound to r, if any, matching on
+// Host first and then on path prefix.
+func (p *ProxyHandler) matchRoute(r *http.Request) (config.Route, bool) {
+	for _, route := range p.config.Routes {
+		if route.Host != "" && route.Host == r.Host {
+			return route, true
+		}
+	}
+	for _, route := range p.config.Routes {
+		if route.PathPrefix != "" && strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+			return route, true
+		}
+	}
+	return config.Route{}, false
+}
+
+// resolvePool finds the upstream pool bound to r's route, if any.
+func (p *ProxyHandler) resolvePool(r *http.Request) *upstream.Pool {
+	if p.pools == nil {
+		return nil
+	}
+	route, ok := p.matchRoute(r)
+	if !ok {
+		return nil
+	}
+	pool, ok := p.pools[route.Pool]
+	if !ok {
+		return nil
+	}
+	return pool
+}
+
+// rewriteToUpstream points proxyReq at the selected upstream instead of
+// whatever host the client originally asked for, preserving the path,
+// query string, and body.
+func rewriteToUpstream(proxyReq *http.Request, u *upstream.Upstream) error {
+	target, err := url.Parse(u.Host)
+	if err != nil {
+		return err
+	}
+	proxyReq.URL.Scheme = target.Scheme
+	proxyReq.URL.Host = target.Host
+	proxyReq.Host = target.Host
+	return nil
+}
+
+// AdminUpstreamsPath is the path the /-/upstreams reporting endpoint is
+// served on.
+const AdminUpstreamsPath = "/-/upstreams"
@@ -0,0 +1,48 @@
+package upstream
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// upstreamStatus is the JSON shape reported for one upstream at the admin
+// endpoint.
+type upstreamStatus struct {
+	Host     string `json:"host"`
+	Weight   int    `json:"weight"`
+	Healthy  bool   `json:"healthy"`
+	InFlight int64  `json:"in_flight"`
+	Failures int32  `json:"failures"`
+}
+
+// poolStatus is the JSON shape reported for one pool.
+type poolStatus struct {
+	Name      string           `json:"name"`
+	Upstreams []upstreamStatus `json:"upstreams"`
+}
+
+// AdminHandler returns an http.HandlerFunc that reports the state of every
+// pool as JSON. Mount it at an admin path such as "/-/upstreams".
+func AdminHandler(pools map[string]*Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := make([]poolStatus, 0, len(pools))
+		for name, pool := range pools {
+			ps := poolStatus{Name: name}
+			for _, u := range pool.Upstreams() {
+				ps.Upstreams = append(ps.Upstreams, upstreamStatus{
+					Host:     u.Host,
+					Weight:   u.Weight,
+					Healthy:  u.Healthy(),
+					InFlight: u.InFlight(),
+					Failures: u.Failures(),
+				})
+			}
+			report = append(report, ps)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package upstream
+
+import (
+	"net/http"
+	"time"
+)
+
+// HealthChecker actively probes every upstream in a Pool on an interval,
+// marking them healthy or unhealthy based on the response. This
+// complements the passive checks Pool.Release performs from real traffic.
+type HealthChecker struct {
+	Path     string        // path to GET on each upstream, e.g. "/healthz"
+	Interval time.Duration // how often to probe
+	Timeout  time.Duration // per-probe timeout
+
+	client *http.Client
+	stopCh chan struct{}
+}
+
+// NewHealthChecker creates a HealthChecker with sane defaults for any
+// zero-valued fields.
+func NewHealthChecker(path string, interval, timeout time.Duration) *HealthChecker {
+	if path == "" {
+		path = "/"
+	}
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return &HealthChecker{
+		Path:     path,
+		Interval: interval,
+		Timeout:  timeout,
+		client:   &http.Client{Timeout: timeout},
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Run starts the background probe loop for pool. It blocks until Stop is
+// called, so callers should invoke it in its own goroutine.
+func (h *HealthChecker) Run(pool *Pool) {
+	ticker := time.NewTicker(h.Interval)
+	defer ticker.Stop()
+
+	h.probeAll(pool)
+	for {
+		select {
+		case <-ticker.C:
+			h.probeAll(pool)
+		case <-h.stopCh:
+			return
+		}
+	}
+}
+
+// Stop ends the probe loop started by Run.
+func (h *HealthChecker) Stop() {
+	close(h.stopCh)
+}
+
+func (h *HealthChecker) probeAll(pool *Pool) {
+	for _, u := range pool.Upstreams() {
+		go h.probe(u)
+	}
+}
+
+func (h *HealthChecker) probe(u *Upstream) {
+	resp, err := h.client.Get(u.Host + h.Path)
+	if err != nil {
+		u.SetHealthy(false)
+		return
+	}
+	defer resp.Body.Close()
+
+	healthy := resp.StatusCode >= 200 && resp.StatusCode < 300
+	u.SetHealthy(healthy)
+}
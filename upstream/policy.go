@@ -0,0 +1,143 @@
+package upstream
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// SelectionPolicy picks one healthy upstream out of candidates for the
+// given request. Implementations must be safe for concurrent use.
+type SelectionPolicy interface {
+	Select(candidates []*Upstream, r *http.Request) *Upstream
+}
+
+// RoundRobinPolicy cycles through candidates in order.
+type RoundRobinPolicy struct {
+	counter uint64
+}
+
+// NewRoundRobinPolicy creates a RoundRobinPolicy.
+func NewRoundRobinPolicy() *RoundRobinPolicy {
+	return &RoundRobinPolicy{}
+}
+
+// Select returns the next candidate in sequence.
+func (p *RoundRobinPolicy) Select(candidates []*Upstream, r *http.Request) *Upstream {
+	if len(candidates) == 0 {
+		return nil
+	}
+	n := atomic.AddUint64(&p.counter, 1)
+	return candidates[(n-1)%uint64(len(candidates))]
+}
+
+// RandomPolicy picks a uniformly random candidate.
+type RandomPolicy struct{}
+
+// Select returns a random candidate.
+func (RandomPolicy) Select(candidates []*Upstream, r *http.Request) *Upstream {
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// LeastConnPolicy picks the candidate with the fewest in-flight requests.
+type LeastConnPolicy struct{}
+
+// Select returns the least-loaded candidate.
+func (LeastConnPolicy) Select(candidates []*Upstream, r *http.Request) *Upstream {
+	if len(candidates) == 0 {
+		return nil
+	}
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.InFlight() < best.InFlight() {
+			best = c
+		}
+	}
+	return best
+}
+
+// IPHashPolicy consistently maps a client IP to the same candidate, so
+// repeat requests from one client land on the same upstream as long as the
+// candidate set doesn't change.
+type IPHashPolicy struct{}
+
+// Select hashes r's remote address to choose a candidate.
+func (IPHashPolicy) Select(candidates []*Upstream, r *http.Request) *Upstream {
+	if len(candidates) == 0 {
+		return nil
+	}
+	key := clientIP(r)
+	return candidates[hashString(key)%uint32(len(candidates))]
+}
+
+// HeaderHashPolicy maps requests to a candidate based on a configured
+// header's value, useful for session affinity.
+type HeaderHashPolicy struct {
+	Header string
+}
+
+// NewHeaderHashPolicy creates a HeaderHashPolicy keyed on the given header.
+func NewHeaderHashPolicy(header string) *HeaderHashPolicy {
+	return &HeaderHashPolicy{Header: header}
+}
+
+// Select hashes the configured header's value to choose a candidate.
+func (p *HeaderHashPolicy) Select(candidates []*Upstream, r *http.Request) *Upstream {
+	if len(candidates) == 0 {
+		return nil
+	}
+	key := r.Header.Get(p.Header)
+	return candidates[hashString(key)%uint32(len(candidates))]
+}
+
+// WeightedRandomPolicy picks candidates at random, proportionally to
+// their Weight.
+type WeightedRandomPolicy struct{}
+
+// Select returns a weighted-random candidate.
+func (WeightedRandomPolicy) Select(candidates []*Upstream, r *http.Request) *Upstream {
+	if len(candidates) == 0 {
+		return nil
+	}
+	total := 0
+	for _, c := range candidates {
+		total += c.Weight
+	}
+	if total <= 0 {
+		return candidates[rand.Intn(len(candidates))]
+	}
+	pick := rand.Intn(total)
+	for _, c := range candidates {
+		pick -= c.Weight
+		if pick < 0 {
+			return c
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// clientIP extracts the request's client address, preferring the leftmost
+// X-Forwarded-For entry if present so the policy hashes the original
+// client rather than an upstream proxy.
+func clientIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		parts := strings.Split(xff, ",")
+		return strings.TrimSpace(parts[0])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
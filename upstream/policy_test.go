@@ -0,0 +1,91 @@
+package upstream
+
+import (
+	"net/http"
+	"testing"
+)
+
+func newTestRequest(t *testing.T, remoteAddr string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func TestRoundRobinPolicyCycles(t *testing.T) {
+	a, b := NewUpstream("http://a", 1), NewUpstream("http://b", 1)
+	candidates := []*Upstream{a, b}
+	policy := NewRoundRobinPolicy()
+	r := newTestRequest(t, "1.2.3.4:1111")
+
+	got := []*Upstream{
+		policy.Select(candidates, r),
+		policy.Select(candidates, r),
+		policy.Select(candidates, r),
+	}
+	want := []*Upstream{a, b, a}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("call %d: got %v, want %v", i, got[i].Host, want[i].Host)
+		}
+	}
+}
+
+func TestLeastConnPolicyPicksFewestInFlight(t *testing.T) {
+	a, b := NewUpstream("http://a", 1), NewUpstream("http://b", 1)
+	a.beginRequest()
+	a.beginRequest()
+	b.beginRequest()
+
+	policy := LeastConnPolicy{}
+	got := policy.Select([]*Upstream{a, b}, newTestRequest(t, "1.2.3.4:1"))
+	if got != b {
+		t.Errorf("got %s, want %s (fewer in-flight requests)", got.Host, b.Host)
+	}
+}
+
+func TestIPHashPolicyIsConsistent(t *testing.T) {
+	candidates := []*Upstream{NewUpstream("http://a", 1), NewUpstream("http://b", 1), NewUpstream("http://c", 1)}
+	policy := IPHashPolicy{}
+	r := newTestRequest(t, "9.9.9.9:5555")
+
+	first := policy.Select(candidates, r)
+	for i := 0; i < 10; i++ {
+		if got := policy.Select(candidates, r); got != first {
+			t.Fatalf("IPHashPolicy picked a different upstream on repeat calls: %s vs %s", got.Host, first.Host)
+		}
+	}
+}
+
+func TestWeightedRandomPolicyOnlyPicksGivenCandidates(t *testing.T) {
+	a := NewUpstream("http://a", 10)
+	b := NewUpstream("http://b", 1)
+	candidates := []*Upstream{a, b}
+	policy := WeightedRandomPolicy{}
+	r := newTestRequest(t, "1.1.1.1:1")
+
+	seen := map[*Upstream]bool{}
+	for i := 0; i < 50; i++ {
+		seen[policy.Select(candidates, r)] = true
+	}
+	if len(seen) == 0 || len(seen) > 2 {
+		t.Fatalf("expected selections drawn only from the two candidates, got %d distinct results", len(seen))
+	}
+}
+
+func TestSelectionPolicyEmptyCandidates(t *testing.T) {
+	r := newTestRequest(t, "1.1.1.1:1")
+	policies := []SelectionPolicy{
+		NewRoundRobinPolicy(), RandomPolicy{}, LeastConnPolicy{}, IPHashPolicy{},
+		NewHeaderHashPolicy("X-Session"), WeightedRandomPolicy{},
+	}
+	for _, p := range policies {
+		if got := p.Select(nil, r); got != nil {
+			t.Errorf("%T.Select(nil, r) = %v, want nil", p, got)
+		}
+	}
+}
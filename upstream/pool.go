@@ -0,0 +1,115 @@
+package upstream
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrNoHealthyUpstream is returned when a Pool has no upstream eligible
+// for selection.
+var ErrNoHealthyUpstream = errors.New("upstream: no healthy upstream available")
+
+// defaultCooldownWindow is how long a passively-ejected upstream sits out
+// before Select gives it another trial request, when CooldownWindow isn't
+// set explicitly.
+const defaultCooldownWindow = 30 * time.Second
+
+// Pool is a set of upstreams load-balanced by a pluggable SelectionPolicy.
+type Pool struct {
+	mu        sync.RWMutex
+	name      string
+	upstreams []*Upstream
+	policy    SelectionPolicy
+
+	// FailureThreshold is how many consecutive passive failures eject an
+	// upstream from selection.
+	FailureThreshold int32
+
+	// CooldownWindow is how long a passively-ejected upstream is excluded
+	// from selection before Select gives it a trial request again. This
+	// lets passive ejection self-heal even when no active HealthChecker
+	// is running for this pool. 0 uses defaultCooldownWindow.
+	CooldownWindow time.Duration
+}
+
+// NewPool creates a Pool of upstreams balanced with policy.
+func NewPool(name string, upstreams []*Upstream, policy SelectionPolicy) *Pool {
+	if policy == nil {
+		policy = NewRoundRobinPolicy()
+	}
+	return &Pool{
+		name:             name,
+		upstreams:        upstreams,
+		policy:           policy,
+		FailureThreshold: 3,
+		CooldownWindow:   defaultCooldownWindow,
+	}
+}
+
+// Name returns the pool's identifier, used to match it against config.
+func (p *Pool) Name() string {
+	return p.name
+}
+
+// Select picks a healthy upstream for r and marks it as having a request
+// in flight. Callers must call Release when the request completes. An
+// upstream whose cooldown window has elapsed since its last passive
+// ejection is given a trial request alongside the healthy candidates;
+// Release will re-eject it immediately if that trial also fails.
+func (p *Pool) Select(r *http.Request) (*Upstream, error) {
+	cooldown := p.CooldownWindow
+	if cooldown <= 0 {
+		cooldown = defaultCooldownWindow
+	}
+
+	p.mu.RLock()
+	candidates := make([]*Upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		if u.Healthy() {
+			candidates = append(candidates, u)
+			continue
+		}
+		if ejectedAt := u.EjectedAt(); !ejectedAt.IsZero() && time.Since(ejectedAt) >= cooldown {
+			candidates = append(candidates, u)
+		}
+	}
+	p.mu.RUnlock()
+
+	u := p.policy.Select(candidates, r)
+	if u == nil {
+		return nil, ErrNoHealthyUpstream
+	}
+	u.beginRequest()
+	return u, nil
+}
+
+// Release marks a request against u as finished and records the outcome
+// for passive health checking. success is false when the request errored
+// or the upstream returned a 5xx/timeout. A successful trial request
+// against a cooled-down, previously-ejected upstream restores it to
+// healthy; a failed one immediately re-ejects it for another cooldown.
+func (p *Pool) Release(u *Upstream, success bool) {
+	u.endRequest()
+	if success {
+		if !u.Healthy() {
+			u.SetHealthy(true)
+		} else {
+			u.recordSuccess()
+		}
+		return
+	}
+	if u.recordFailure() >= p.FailureThreshold || !u.Healthy() {
+		u.SetHealthy(false)
+	}
+}
+
+// Upstreams returns a snapshot of the pool's members, for reporting.
+func (p *Pool) Upstreams() []*Upstream {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]*Upstream, len(p.upstreams))
+	copy(out, p.upstreams)
+	return out
+}
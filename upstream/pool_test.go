@@ -0,0 +1,104 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPoolSelectExcludesUnhealthyOutsideCooldown(t *testing.T) {
+	a := NewUpstream("http://a", 1)
+	b := NewUpstream("http://b", 1)
+	a.SetHealthy(false)
+
+	pool := NewPool("p", []*Upstream{a, b}, NewRoundRobinPolicy())
+	pool.CooldownWindow = time.Hour
+
+	for i := 0; i < 5; i++ {
+		got, err := pool.Select(newTestRequest(t, "1.1.1.1:1"))
+		if err != nil {
+			t.Fatalf("Select: %v", err)
+		}
+		if got != b {
+			t.Fatalf("Select() = %s, want only the healthy upstream %s", got.Host, b.Host)
+		}
+		pool.Release(got, true)
+	}
+}
+
+func TestPoolSelectAdmitsEjectedUpstreamAfterCooldown(t *testing.T) {
+	a := NewUpstream("http://a", 1)
+	a.SetHealthy(false)
+
+	pool := NewPool("p", []*Upstream{a}, NewRoundRobinPolicy())
+	pool.CooldownWindow = time.Millisecond
+
+	time.Sleep(5 * time.Millisecond)
+
+	got, err := pool.Select(newTestRequest(t, "1.1.1.1:1"))
+	if err != nil {
+		t.Fatalf("Select should admit a cooled-down upstream as a trial candidate: %v", err)
+	}
+	if got != a {
+		t.Fatalf("Select() = %v, want the cooled-down upstream", got)
+	}
+}
+
+func TestPoolReleaseRestoresHealthOnSuccessfulTrial(t *testing.T) {
+	a := NewUpstream("http://a", 1)
+	a.SetHealthy(false)
+
+	pool := NewPool("p", []*Upstream{a}, NewRoundRobinPolicy())
+	pool.CooldownWindow = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+
+	u, err := pool.Select(newTestRequest(t, "1.1.1.1:1"))
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+
+	pool.Release(u, true)
+
+	if !a.Healthy() {
+		t.Error("a successful trial request should restore Healthy() to true")
+	}
+	if !a.EjectedAt().IsZero() {
+		t.Error("a successful trial request should clear EjectedAt")
+	}
+}
+
+func TestPoolReleaseReEjectsOnFailedTrial(t *testing.T) {
+	a := NewUpstream("http://a", 1)
+	a.SetHealthy(false)
+	firstEject := a.EjectedAt()
+
+	pool := NewPool("p", []*Upstream{a}, NewRoundRobinPolicy())
+	pool.FailureThreshold = 1
+	pool.CooldownWindow = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+
+	u, err := pool.Select(newTestRequest(t, "1.1.1.1:1"))
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+
+	pool.Release(u, false)
+
+	if a.Healthy() {
+		t.Error("a failed trial request should leave the upstream ejected")
+	}
+	if !a.EjectedAt().After(firstEject) {
+		t.Error("a failed trial request should refresh EjectedAt so the cooldown restarts")
+	}
+}
+
+func TestPoolSelectNoHealthyUpstream(t *testing.T) {
+	a := NewUpstream("http://a", 1)
+	a.SetHealthy(false)
+
+	pool := NewPool("p", []*Upstream{a}, NewRoundRobinPolicy())
+	pool.CooldownWindow = time.Hour
+
+	if _, err := pool.Select(newTestRequest(t, "1.1.1.1:1")); err != ErrNoHealthyUpstream {
+		t.Errorf("Select() error = %v, want ErrNoHealthyUpstream", err)
+	}
+}
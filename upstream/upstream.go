@@ -0,0 +1,96 @@
+// Package upstream implements a health-checked pool of backend targets
+// that the proxy can load-balance across, as an alternative to forwarding
+// directly to the client-supplied host.
+package upstream
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Upstream is a single backend target in a Pool.
+type Upstream struct {
+	// Host is the target's scheme://host[:port], e.g. "http://10.0.0.5:8080".
+	Host string
+
+	// Weight is used by weighted-random selection; higher means more
+	// traffic. Ignored by the other policies.
+	Weight int
+
+	inFlight  int64 // atomic: requests currently being served by this upstream
+	healthy   int32 // atomic: 1 if healthy, 0 if ejected
+	failures  int32 // atomic: consecutive failures observed by passive checks
+	ejectedAt int64 // atomic: UnixNano when last marked unhealthy, 0 if never/healthy
+}
+
+// NewUpstream creates an Upstream, healthy by default.
+func NewUpstream(host string, weight int) *Upstream {
+	if weight <= 0 {
+		weight = 1
+	}
+	u := &Upstream{Host: host, Weight: weight}
+	atomic.StoreInt32(&u.healthy, 1)
+	return u
+}
+
+// Healthy reports whether the upstream is currently eligible for selection.
+func (u *Upstream) Healthy() bool {
+	return atomic.LoadInt32(&u.healthy) == 1
+}
+
+// SetHealthy marks the upstream as healthy or ejected, recording the
+// ejection time so a Pool's cooldown window can later let it self-heal
+// even without an active HealthChecker configured.
+func (u *Upstream) SetHealthy(healthy bool) {
+	if healthy {
+		atomic.StoreInt32(&u.healthy, 1)
+		atomic.StoreInt32(&u.failures, 0)
+		atomic.StoreInt64(&u.ejectedAt, 0)
+	} else {
+		atomic.StoreInt32(&u.healthy, 0)
+		atomic.StoreInt64(&u.ejectedAt, time.Now().UnixNano())
+	}
+}
+
+// EjectedAt returns when the upstream was last marked unhealthy, or the
+// zero Time if it's currently healthy or has never been ejected.
+func (u *Upstream) EjectedAt() time.Time {
+	nanos := atomic.LoadInt64(&u.ejectedAt)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// InFlight returns the number of requests currently in progress against
+// this upstream, used by the least-conn policy.
+func (u *Upstream) InFlight() int64 {
+	return atomic.LoadInt64(&u.inFlight)
+}
+
+// beginRequest records that a request started against this upstream.
+func (u *Upstream) beginRequest() {
+	atomic.AddInt64(&u.inFlight, 1)
+}
+
+// endRequest records that a request finished against this upstream.
+func (u *Upstream) endRequest() {
+	atomic.AddInt64(&u.inFlight, -1)
+}
+
+// Failures returns the current consecutive-failure count tracked by
+// passive health checks.
+func (u *Upstream) Failures() int32 {
+	return atomic.LoadInt32(&u.failures)
+}
+
+// recordFailure increments the consecutive-failure count and returns the
+// new value.
+func (u *Upstream) recordFailure() int32 {
+	return atomic.AddInt32(&u.failures, 1)
+}
+
+// recordSuccess resets the consecutive-failure count.
+func (u *Upstream) recordSuccess() {
+	atomic.StoreInt32(&u.failures, 0)
+}